@@ -0,0 +1,58 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+)
+
+// invalidateRequest identifies the cache entries to evict. OrgID is
+// required; ApiKey and SensorID are each optional and independent, so a
+// single call can evict either or both for an org.
+type invalidateRequest struct {
+	OrgID    string `json:"orgId"`
+	APIKey   string `json:"apiKey"`
+	SensorID string `json:"sensorId"`
+}
+
+// InvalidateHandler handles POST /v1/admin/invalidate, letting the
+// dashboard evict a cached API key or sensor lookup as soon as it's
+// revoked or deleted rather than waiting out the TTL.
+func InvalidateHandler(w http.ResponseWriter, r *http.Request) {
+	if err := initClients(); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	adminKey := os.Getenv("ADMIN_KEY")
+	if adminKey == "" || r.Header.Get("X-Admin-Key") != adminKey {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req invalidateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.OrgID == "" {
+		http.Error(w, "Missing orgId", http.StatusBadRequest)
+		return
+	}
+
+	if req.APIKey != "" {
+		authCache.Invalidate(apiKeyCacheKey(req.OrgID, req.APIKey))
+	}
+	if req.SensorID != "" {
+		authCache.Invalidate(sensorCacheKey(req.OrgID, req.SensorID))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"status":"invalidated"}`))
+}
@@ -8,14 +8,21 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"cloud.google.com/go/firestore"
 	"github.com/InfluxCommunity/influxdb3-go/v2/influxdb3"
-	"golang.org/x/time/rate"
+	"github.com/redis/go-redis/v9"
 	"google.golang.org/api/option"
+
+	"influxdb_go_client/authcache"
+	"influxdb_go_client/metrics"
+	"influxdb_go_client/ratelimit"
+	"influxdb_go_client/spool"
 )
 
 type IReadingType any
@@ -28,9 +35,16 @@ type SensorReading struct {
 }
 
 var (
-	// Rate‐limiters per (apiKey, sensorID)
-	limiters = make(map[string]*rate.Limiter)
-	mu       sync.Mutex
+	// Rate limiter for (apiKey, sensorID) keys. Backed by an in-process
+	// token bucket by default, or a Redis-shared one when REDIS_ADDR is
+	// set, so multiple serverless instances share a single bucket.
+	rateLimiter ratelimit.Limiter = ratelimit.NewInProcess(2, 2) // 2 events per second, burst size 2
+
+	// Redis-backed quota counter for retention-tier usage. Left nil when
+	// REDIS_ADDR isn't configured, in which case validateCountLimit falls
+	// back to a live Influx COUNT query.
+	quotaCounter *ratelimit.QuotaCounter
+	redisClient  *redis.Client
 
 	// Firestore client
 	firestoreClient *firestore.Client
@@ -39,6 +53,14 @@ var (
 	// InfluxDB v3 client
 	influxClient *influxdb3.Client
 
+	// One durable write-ahead spool per retention bucket, so a slow or
+	// unavailable InfluxDB never blocks the HTTP response.
+	spools   = make(map[string]*spool.Spool)
+	spoolDir = "spool-data"
+
+	// TTL cache of Firestore API-key and sensor-id lookups
+	authCache = authcache.New()
+
 	// Retention‐to‐bucket mapping
 	retentionBuckets = map[string]string{
 		"0007": "retention_7d",
@@ -98,10 +120,54 @@ func initClients() error {
 		return err
 	}
 
+	if dir := os.Getenv("SPOOL_DIR"); dir != "" {
+		spoolDir = dir
+	}
+	for _, bucket := range retentionBuckets {
+		s, err := spool.Open(filepath.Join(spoolDir, bucket), bucketWriteFunc(bucket))
+		if err != nil {
+			return fmt.Errorf("opening spool for bucket %s: %w", bucket, err)
+		}
+		s.Start(context.Background())
+		spools[bucket] = s
+	}
+
+	// Redis-backed rate limiting and quota tracking, so usage is shared
+	// across every serverless instance instead of living in-process.
+	if redisAddr := os.Getenv("REDIS_ADDR"); redisAddr != "" {
+		redisClient = redis.NewClient(&redis.Options{
+			Addr:     redisAddr,
+			Password: os.Getenv("REDIS_PASSWORD"),
+		})
+		rateLimiter = ratelimit.NewRedis(redisClient, 2, time.Second)
+		quotaCounter = ratelimit.NewQuotaCounter(redisClient)
+	}
+
 	initialized = true
 	return nil
 }
 
+// bucketWriteFunc returns the flush callback a bucket's spool uses to
+// batch its accumulated records into InfluxDB.
+func bucketWriteFunc(bucket string) spool.WriteFunc {
+	return func(ctx context.Context, records [][]byte) error {
+		var total int
+		for _, r := range records {
+			total += len(r) + 1
+		}
+		payload := make([]byte, 0, total)
+		for _, r := range records {
+			payload = append(payload, r...)
+			payload = append(payload, '\n')
+		}
+
+		start := time.Now()
+		err := influxClient.Write(ctx, payload, influxdb3.WithDatabase(bucket))
+		metrics.InfluxWriteSeconds.Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
 // Handler is the main Vercel serverless function handler
 func UploadHandler(w http.ResponseWriter, r *http.Request) {
 	// Initialize clients on first request (cold start optimization)
@@ -115,8 +181,15 @@ func UploadHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleUpload(w http.ResponseWriter, r *http.Request) {
+	var orgID string
+	status := "error"
+	defer func() {
+		metrics.UploadRequestsTotal.WithLabelValues(metrics.OrgLabel(orgID), status).Inc()
+	}()
+
 	// Step 1: Check Method
 	if r.Method != http.MethodPost {
+		status = "method_not_allowed"
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 		return
 	}
@@ -124,28 +197,32 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 	// Step 2: Check auth header
 	auth := r.Header.Get("Authorization")
 	if !strings.HasPrefix(auth, "Bearer ") {
+		status = "unauthorized"
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 	apiKey := strings.TrimPrefix(auth, "Bearer ")
 
 	// Step 3: Extract query params
-	orgID := r.URL.Query().Get("org_id")
+	orgID = r.URL.Query().Get("org_id")
 	sensorID := r.URL.Query().Get("sensor_id")
 	if orgID == "" || sensorID == "" {
+		status = "bad_request"
 		http.Error(w, "Missing org_id or sensor_id query parameter", http.StatusBadRequest)
 		return
 	}
 
 	// Step 4: Validate API key, permissions and sensor id
-	if (!validateAPIKey(orgID, apiKey) || !validateSensorID(orgID, sensorID)){
+	if !validateAPIKey(r, orgID, apiKey) || !validateSensorID(r, orgID, sensorID) {
+		status = "unauthorized"
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
 	// Step 5: Rate‐limit per (apiKey, sensorID)
-	limiter := getRateLimiter(apiKey, sensorID)
-	if !limiter.Allow() {
+	if !rateLimiter.Allow(rateLimiterKey(apiKey, sensorID)) {
+		status = "rate_limited"
+		metrics.RateLimitedTotal.WithLabelValues(metrics.OrgLabel(orgID)).Inc()
 		http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
 		return
 	}
@@ -153,6 +230,7 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 	// Step 6: Decode payload
 	var reading SensorReading
 	if err := json.NewDecoder(r.Body).Decode(&reading); err != nil {
+		status = "bad_request"
 		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
 		return
 	}
@@ -160,6 +238,7 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 		reading.SensorID = &sensorID
 	}
 	if reading.Timestamp == nil {
+		status = "bad_request"
 		http.Error(w, "Missing timestamp", http.StatusBadRequest)
 		return
 	}
@@ -174,6 +253,7 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 		ts = time.UnixMilli(raw).UTC()
 	}
 	if err := validateReading(reading); err != nil {
+		status = "bad_request"
 		http.Error(w, "Bad Reading: "+err.Error(), http.StatusBadRequest)
 		return
 	}
@@ -182,12 +262,14 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 	retentionCode := apiKey[len(apiKey)-6 : len(apiKey)-2]
 	bucket := retentionBuckets[retentionCode]
 	if bucket == "" {
+		status = "bad_request"
 		http.Error(w, "Unknown retention level", http.StatusBadRequest)
 		return
 	}
 
 	// Step 8: Check count limit
-	if !validateCountLimit(orgID, retentionCode, bucket, w) {
+	if !validateCountLimit(r, orgID, retentionCode, bucket, w) {
+		status = "rejected"
 		return
 	}
 
@@ -207,15 +289,17 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Step 11: Write to InfluxDB with proper bucket using WriteOptions
-	writeOptions := []influxdb3.WriteOption{
-		influxdb3.WithDatabase(bucket),
-	}
-	if err := influxClient.Write(context.Background(), lineProtocolBytes, writeOptions...); err != nil {
-		log.Printf("Influx write error: %v", err)
-		http.Error(w, "Failed to write data", http.StatusInternalServerError)
+	// Step 11: Persist to the bucket's durable spool; a background writer
+	// flushes it into InfluxDB, so a stalled or unreachable Influx no
+	// longer turns into a 500 for the caller.
+	if err := spools[bucket].Append(lineProtocolBytes); err != nil {
+		log.Printf("Spool append error: %v", err)
+		http.Error(w, "Failed to process data", http.StatusInternalServerError)
 		return
 	}
+	incrQuota(r, orgID, bucket, 1)
+	metrics.UploadBytesTotal.WithLabelValues(metrics.OrgLabel(orgID), bucket).Add(float64(len(lineProtocolBytes)))
+	status = "accepted"
 
 	// Step 12: Respond
 	w.Header().Set("Content-Type", "application/json")
@@ -223,43 +307,98 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(`{"status":"accepted"}`))
 }
 
-func validateCountLimit(orgID, retentionCode, bucket string, w http.ResponseWriter) bool {
-	quotaMap := map[string]int64{
-		"0007": 10_000,
-		"0030": 100_000,
-		"0090": 1_000_000,
-		"0180": 10_000_000,
-		"0365": 1_000_000_000,
-	}
-	maxPoints, ok := quotaMap[retentionCode]
-	if !ok {
-		http.Error(w, "Unknown retention tier", http.StatusBadRequest)
-		return false
+// Default per-operation deadlines. A caller can further tighten these (but
+// never extend them) via the X-Request-Timeout-Ms header. There's no
+// request-scoped Influx write deadline here: writes go through the durable
+// spool (see the spool package), so the handler never blocks on Influx.
+const (
+	firestoreTimeout  = 2 * time.Second
+	countQueryTimeout = 5 * time.Second
+)
+
+// setDeadline derives a context from r that expires after at most def,
+// honoring a tighter X-Request-Timeout-Ms header from the caller. This
+// mirrors how net.Conn deadline propagation lets the nearer side win.
+func setDeadline(r *http.Request, def time.Duration) (context.Context, context.CancelFunc) {
+	timeout := def
+	if v := r.Header.Get("X-Request-Timeout-Ms"); v != "" {
+		if ms, err := strconv.Atoi(v); err == nil && ms > 0 {
+			if requested := time.Duration(ms) * time.Millisecond; requested < timeout {
+				timeout = requested
+			}
+		}
 	}
+	return context.WithTimeout(r.Context(), timeout)
+}
+
+// quotaLimits maps a retention code to the max points an org may hold in
+// that tier's bucket.
+var quotaLimits = map[string]int64{
+	"0007": 10_000,
+	"0030": 100_000,
+	"0090": 1_000_000,
+	"0180": 10_000_000,
+	"0365": 1_000_000_000,
+}
 
+// quotaKey is the Redis key a bucket's quota counter is tracked under.
+func quotaKey(orgID, bucket string) string {
+	return "quota:" + bucket + ":" + orgID
+}
+
+// currentOrgCount runs the live Influx COUNT(*) query for orgID's usage of
+// bucket. It's the source of truth the Redis quota counter is seeded from.
+func currentOrgCount(ctx context.Context, orgID, bucket string) (int64, error) {
 	sql := fmt.Sprintf(`
 		SELECT COUNT(*) AS count FROM sensor_reading WHERE "org" = '%s'
 	`, orgID)
 
-	iterator, err := influxClient.Query(context.Background(), sql, influxdb3.WithDatabase(bucket))
+	iterator, err := influxClient.Query(ctx, sql, influxdb3.WithDatabase(bucket))
 	if err != nil {
-		log.Printf("(validateCountLimit) Influx query error: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return false
+		return 0, err
 	}
 
-	var currentCount int64
+	var count int64
 	for !iterator.Done() {
 		if !iterator.Next() {
 			break
 		}
 		v := iterator.Value()
 		if num, ok := v["count"].(int64); ok { // values come as map[string]interface{}
-			currentCount = num
+			count = num
 		}
 	}
 	if iterator.Err() != nil {
-		log.Printf("(validateCountLimit) Error reading count: %v", iterator.Err())
+		return 0, iterator.Err()
+	}
+	return count, nil
+}
+
+// incrQuota records n newly-written points for orgID's usage of bucket in
+// the Redis quota counter, if one is configured. It's a best-effort
+// bookkeeping step: a failure here doesn't fail the write, since the next
+// reconcile cycle will correct the count from Influx.
+func incrQuota(r *http.Request, orgID, bucket string, n int64) {
+	if quotaCounter == nil {
+		return
+	}
+	ctx, cancel := setDeadline(r, firestoreTimeout)
+	defer cancel()
+	if _, err := quotaCounter.Incr(ctx, quotaKey(orgID, bucket), n); err != nil {
+		log.Printf("quota incr error: %v", err)
+	}
+}
+
+func validateCountLimit(r *http.Request, orgID, retentionCode, bucket string, w http.ResponseWriter) bool {
+	maxPoints, ok := quotaLimits[retentionCode]
+	if !ok {
+		http.Error(w, "Unknown retention tier", http.StatusBadRequest)
+		return false
+	}
+
+	currentCount, err := orgQuotaCount(r, orgID, bucket)
+	if err != nil {
+		log.Printf("(validateCountLimit) quota lookup error: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return false
 	}
@@ -271,49 +410,91 @@ func validateCountLimit(orgID, retentionCode, bucket string, w http.ResponseWrit
 	return true
 }
 
-func validateAPIKey(orgID, apiKey string) bool {
+// orgQuotaCount returns orgID's current point count in bucket, from the
+// Redis quota counter when configured (falling back to a live Influx COUNT
+// query to seed or reconcile it), or directly from Influx otherwise.
+func orgQuotaCount(r *http.Request, orgID, bucket string) (int64, error) {
+	ctx, cancel := setDeadline(r, countQueryTimeout)
+	defer cancel()
+
+	var count int64
+	var err error
+	if quotaCounter == nil {
+		count, err = currentOrgCount(ctx, orgID, bucket)
+	} else {
+		count, err = quotaCounter.Get(ctx, quotaKey(orgID, bucket), func(ctx context.Context) (int64, error) {
+			return currentOrgCount(ctx, orgID, bucket)
+		})
+	}
+	if err == nil {
+		metrics.QuotaUsed.WithLabelValues(metrics.OrgLabel(orgID), bucket).Set(float64(count))
+	}
+	return count, err
+}
+
+func apiKeyCacheKey(orgID, apiKey string) string {
+	return "apiKey:" + orgID + ":" + apiKey
+}
+
+func sensorCacheKey(orgID, sensorID string) string {
+	return "sensor:" + orgID + ":" + sensorID
+}
+
+func validateAPIKey(r *http.Request, orgID, apiKey string) bool {
 	// Step 1: Check API key permissions
 	if !isUploadAllowed(apiKey) {
 		return false
 	}
 
-	// Step 2: Check to see if the api key exists
+	// Step 2: Serve from the TTL cache when available to avoid a
+	// Firestore round trip on every upload.
+	cacheKey := apiKeyCacheKey(orgID, apiKey)
+	if valid, found := authCache.Get(cacheKey); found {
+		return valid
+	}
+
+	// Step 3: Check to see if the api key exists
 	docRef := firestoreClient.
 		Collection("tokens").
 		Doc(orgID).
 		Collection("apiKeys").
 		Doc(apiKey)
 
-	// Use a short timeout to avoid hanging
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	ctx, cancel := setDeadline(r, firestoreTimeout)
 	defer cancel()
 
+	start := time.Now()
 	_, err := docRef.Get(ctx)
-	if err != nil {
-		// Not found or some other error
-		return false
-	}
-	return true
+	metrics.FirestoreLookupSeconds.WithLabelValues("apiKey").Observe(time.Since(start).Seconds())
+	valid := err == nil
+	authCache.Set(cacheKey, valid)
+	return valid
 }
 
-func validateSensorID(orgID, sensorId string) bool {
-	// Step 1: Check to see if the api key exists
+func validateSensorID(r *http.Request, orgID, sensorId string) bool {
+	// Step 1: Serve from the TTL cache when available to avoid a
+	// Firestore round trip on every upload.
+	cacheKey := sensorCacheKey(orgID, sensorId)
+	if valid, found := authCache.Get(cacheKey); found {
+		return valid
+	}
+
+	// Step 2: Check to see if the sensor exists
 	docRef := firestoreClient.
 		Collection("devices").
 		Doc(orgID).
 		Collection("sensors").
 		Doc(sensorId)
 
-	// Use a short timeout to avoid hanging
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	ctx, cancel := setDeadline(r, firestoreTimeout)
 	defer cancel()
 
+	start := time.Now()
 	_, err := docRef.Get(ctx)
-	if err != nil {
-		// Not found or some other error
-		return false
-	}
-	return true
+	metrics.FirestoreLookupSeconds.WithLabelValues("sensor").Observe(time.Since(start).Seconds())
+	valid := err == nil
+	authCache.Set(cacheKey, valid)
+	return valid
 }
 
 func validateReading(r SensorReading) error {
@@ -328,19 +509,8 @@ func validateReading(r SensorReading) error {
 	}
 }
 
-func getRateLimiter(apiKey string, sensorID string) *rate.Limiter {
-	key := apiKey + ":" + sensorID
-
-	mu.Lock()
-	defer mu.Unlock()
-
-	limiter, exists := limiters[key]
-	if !exists {
-		limiter = rate.NewLimiter(2, 2) // 2 events per second, burst size 2
-		limiters[key] = limiter
-	}
-
-	return limiter
+func rateLimiterKey(apiKey, sensorID string) string {
+	return apiKey + ":" + sensorID
 }
 
 func isUploadAllowed(apiKey string) bool {
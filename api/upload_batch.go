@@ -0,0 +1,291 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/InfluxCommunity/influxdb3-go/v2/influxdb3"
+
+	"influxdb_go_client/metrics"
+)
+
+const (
+	maxBatchPoints = 5000
+	maxBatchBytes  = 5 * 1024 * 1024 // 5 MiB
+)
+
+// BatchResult reports the outcome of a single reading within a batch upload.
+type BatchResult struct {
+	Index  int    `json:"index"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// BatchUploadHandler is the main Vercel serverless function handler for batch uploads.
+func BatchUploadHandler(w http.ResponseWriter, r *http.Request) {
+	// Initialize clients on first request (cold start optimization)
+	if err := initClients(); err != nil {
+		log.Printf("Failed to initialize clients: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	handleBatchUpload(w, r)
+}
+
+func handleBatchUpload(w http.ResponseWriter, r *http.Request) {
+	var orgID string
+	status := "error"
+	defer func() {
+		metrics.UploadRequestsTotal.WithLabelValues(metrics.OrgLabel(orgID), status).Inc()
+	}()
+
+	// Step 1: Check Method
+	if r.Method != http.MethodPost {
+		status = "method_not_allowed"
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Step 2: Check auth header
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		status = "unauthorized"
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	apiKey := strings.TrimPrefix(auth, "Bearer ")
+
+	// Step 3: Extract query params
+	orgID = r.URL.Query().Get("org_id")
+	sensorID := r.URL.Query().Get("sensor_id")
+	if orgID == "" || sensorID == "" {
+		status = "bad_request"
+		http.Error(w, "Missing org_id or sensor_id query parameter", http.StatusBadRequest)
+		return
+	}
+
+	// Step 4: Validate API key and default sensor id once; individual readings
+	// may still target other sensors and are checked one by one in Step 7.
+	if !validateAPIKey(r, orgID, apiKey) || !validateSensorID(r, orgID, sensorID) {
+		status = "unauthorized"
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Step 5: Extract retention code and select bucket
+	retentionCode := apiKey[len(apiKey)-6 : len(apiKey)-2]
+	bucket := retentionBuckets[retentionCode]
+	if bucket == "" {
+		status = "bad_request"
+		http.Error(w, "Unknown retention level", http.StatusBadRequest)
+		return
+	}
+
+	// Step 6: Read and cap the body before decoding anything
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBatchBytes+1))
+	if err != nil {
+		status = "bad_request"
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxBatchBytes {
+		status = "bad_request"
+		http.Error(w, fmt.Sprintf("Batch exceeds max size of %d bytes", maxBatchBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	readings, err := decodeBatch(body)
+	if err != nil {
+		status = "bad_request"
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(readings) == 0 {
+		status = "bad_request"
+		http.Error(w, "Batch is empty", http.StatusBadRequest)
+		return
+	}
+	if len(readings) > maxBatchPoints {
+		status = "bad_request"
+		http.Error(w, fmt.Sprintf("Batch exceeds max of %d points", maxBatchPoints), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// Step 7: Validate each reading individually, tracking which sensor ids
+	// have already passed validation to avoid redundant Firestore lookups.
+	sensorChecked := map[string]bool{sensorID: true}
+	results := make([]BatchResult, len(readings))
+	var lineProtocol [][]byte
+
+	for i, reading := range readings {
+		if reading.SensorID == nil || *reading.SensorID == "" {
+			reading.SensorID = &sensorID
+		}
+		if !sensorChecked[*reading.SensorID] {
+			if !validateSensorID(r, orgID, *reading.SensorID) {
+				results[i] = BatchResult{Index: i, Status: "error", Error: "unknown sensor id"}
+				continue
+			}
+			sensorChecked[*reading.SensorID] = true
+		}
+
+		point, err := buildPoint(reading, orgID)
+		if err != nil {
+			results[i] = BatchResult{Index: i, Status: "error", Error: err.Error()}
+			continue
+		}
+
+		bytes, err := point.MarshalBinary(0) // 0 for nanosecond precision
+		if err != nil {
+			results[i] = BatchResult{Index: i, Status: "error", Error: "failed to encode point"}
+			continue
+		}
+
+		lineProtocol = append(lineProtocol, bytes)
+		results[i] = BatchResult{Index: i, Status: "accepted"}
+	}
+
+	// Step 8: Rate‐limit per (apiKey, sensorID), reserving one token per
+	// accepted reading rather than one token for the whole request, so a
+	// 5000-point batch costs the same as 5000 single uploads would.
+	if len(lineProtocol) > 0 {
+		if ok, err := rateLimiter.Reserve(rateLimiterKey(apiKey, sensorID), int64(len(lineProtocol))); err != nil || !ok {
+			status = "rate_limited"
+			metrics.RateLimitedTotal.WithLabelValues(metrics.OrgLabel(orgID)).Inc()
+			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	// Step 9: Reject the whole batch up front if it would blow the retention quota
+	if len(lineProtocol) > 0 {
+		if !validateBatchCountLimit(r, orgID, retentionCode, bucket, len(lineProtocol)) {
+			status = "rejected"
+			http.Error(w, "Quota exceeded", http.StatusPaymentRequired)
+			return
+		}
+	}
+
+	// Step 10: Persist every accepted point to the bucket's durable spool
+	// in one call, concatenated as line protocol; the background writer
+	// flushes it into InfluxDB.
+	if len(lineProtocol) > 0 {
+		payload := joinLineProtocol(lineProtocol)
+		if err := spools[bucket].Append(payload); err != nil {
+			log.Printf("Spool append error: %v", err)
+			http.Error(w, "Failed to process data", http.StatusInternalServerError)
+			return
+		}
+		incrQuota(r, orgID, bucket, int64(len(lineProtocol)))
+		metrics.UploadBytesTotal.WithLabelValues(metrics.OrgLabel(orgID), bucket).Add(float64(len(payload)))
+	}
+	status = "accepted"
+
+	// Step 11: Respond 207-style with per-index results
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusMultiStatus)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "multi",
+		"results": results,
+	})
+}
+
+// decodeBatch accepts either a JSON array of SensorReading or newline-delimited JSON.
+func decodeBatch(body []byte) ([]SensorReading, error) {
+	trimmed := strings.TrimSpace(string(body))
+	if trimmed == "" {
+		return nil, nil
+	}
+
+	if strings.HasPrefix(trimmed, "[") {
+		var readings []SensorReading
+		if err := json.Unmarshal(body, &readings); err != nil {
+			return nil, err
+		}
+		return readings, nil
+	}
+
+	var readings []SensorReading
+	scanner := bufio.NewScanner(strings.NewReader(trimmed))
+	scanner.Buffer(make([]byte, 0, 64*1024), maxBatchBytes)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var reading SensorReading
+		if err := json.Unmarshal([]byte(line), &reading); err != nil {
+			return nil, err
+		}
+		readings = append(readings, reading)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return readings, nil
+}
+
+// buildPoint validates a single reading and converts it into an Influx point.
+func buildPoint(reading SensorReading, orgID string) (*influxdb3.Point, error) {
+	if reading.Timestamp == nil {
+		return nil, fmt.Errorf("missing timestamp")
+	}
+	if err := validateReading(reading); err != nil {
+		return nil, err
+	}
+
+	raw := *reading.Timestamp
+	var ts time.Time
+	if raw < 1_000_000_000_000 {
+		// likely seconds
+		ts = time.Unix(raw, 0).UTC()
+	} else {
+		// milliseconds
+		ts = time.UnixMilli(raw).UTC()
+	}
+
+	tags := map[string]string{"org": orgID, "sensor": *reading.SensorID}
+	fields := map[string]interface{}{"value": reading.Value}
+	if reading.Status != nil {
+		fields["status"] = *reading.Status
+	}
+	return influxdb3.NewPoint("sensor_reading", tags, fields, ts), nil
+}
+
+// joinLineProtocol concatenates marshaled points into a single newline-separated payload.
+func joinLineProtocol(points [][]byte) []byte {
+	var total int
+	for _, p := range points {
+		total += len(p) + 1
+	}
+	out := make([]byte, 0, total)
+	for _, p := range points {
+		out = append(out, p...)
+		out = append(out, '\n')
+	}
+	return out
+}
+
+// validateBatchCountLimit checks that writing addPoints more points would not
+// push the org past its retention-tier quota.
+func validateBatchCountLimit(r *http.Request, orgID, retentionCode, bucket string, addPoints int) bool {
+	maxPoints, ok := quotaLimits[retentionCode]
+	if !ok {
+		return false
+	}
+
+	currentCount, err := orgQuotaCount(r, orgID, bucket)
+	if err != nil {
+		log.Printf("(validateBatchCountLimit) quota lookup error: %v", err)
+		return false
+	}
+
+	return currentCount+int64(addPoints) <= maxPoints
+}
@@ -0,0 +1,441 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+
+	"influxdb_go_client/metrics"
+)
+
+const (
+	maxSessionChunkBytes = 5 * 1024 * 1024 // 5 MiB per PATCH
+	sessionCollection    = "uploadSessions"
+)
+
+// sessionState is the Firestore-persisted record for a resumable upload
+// session, keyed by UUID so any serverless instance can pick up a PATCH
+// for a session another instance started.
+type sessionState struct {
+	OrgID         string    `firestore:"orgId"`
+	SensorID      string    `firestore:"sensorId"`
+	RetentionCode string    `firestore:"retentionCode"`
+	Bucket        string    `firestore:"bucket"`
+	Offset        int64     `firestore:"offset"`
+	QuotaConsumed int64     `firestore:"quotaConsumed"`
+	HashState     []byte    `firestore:"hashState"`
+	CreatedAt     time.Time `firestore:"createdAt"`
+	UpdatedAt     time.Time `firestore:"updatedAt"`
+	Closed        bool      `firestore:"closed"`
+}
+
+// UploadSessionHandler handles POST /v1/upload/session, which opens a new
+// resumable ingest session for a large historical backfill.
+func UploadSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if err := initClients(); err != nil {
+		log.Printf("Failed to initialize clients: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	apiKey := strings.TrimPrefix(auth, "Bearer ")
+
+	orgID := r.URL.Query().Get("org_id")
+	sensorID := r.URL.Query().Get("sensor_id")
+	if orgID == "" || sensorID == "" {
+		http.Error(w, "Missing org_id or sensor_id query parameter", http.StatusBadRequest)
+		return
+	}
+
+	if !validateAPIKey(r, orgID, apiKey) || !validateSensorID(r, orgID, sensorID) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	retentionCode := apiKey[len(apiKey)-6 : len(apiKey)-2]
+	bucket := retentionBuckets[retentionCode]
+	if bucket == "" {
+		http.Error(w, "Unknown retention level", http.StatusBadRequest)
+		return
+	}
+
+	uuid, err := newSessionUUID()
+	if err != nil {
+		log.Printf("Failed to generate session uuid: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	h := sha256.New()
+	hashState, err := h.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		log.Printf("Failed to marshal hash state: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now().UTC()
+	state := sessionState{
+		OrgID:         orgID,
+		SensorID:      sensorID,
+		RetentionCode: retentionCode,
+		Bucket:        bucket,
+		Offset:        0,
+		QuotaConsumed: 0,
+		HashState:     hashState,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	ctx, cancel := setDeadline(r, firestoreTimeout)
+	defer cancel()
+	if _, err := sessionDoc(uuid).Set(ctx, state); err != nil {
+		log.Printf("Failed to create session: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	location := "/v1/upload/session/" + uuid
+	w.Header().Set("Location", location)
+	w.Header().Set("Docker-Upload-UUID", uuid)
+	w.Header().Set("Range", "bytes=0-0")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte(`{"status":"created","uuid":"` + uuid + `"}`))
+}
+
+// UploadSessionItemHandler handles PATCH and PUT on
+// /v1/upload/session/{uuid}, appending a chunk of readings to an open
+// session or committing it.
+func UploadSessionItemHandler(w http.ResponseWriter, r *http.Request) {
+	if err := initClients(); err != nil {
+		log.Printf("Failed to initialize clients: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	uuid := strings.TrimPrefix(r.URL.Path, "/v1/upload/session/")
+	if uuid == "" {
+		http.Error(w, "Missing session id", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := setDeadline(r, firestoreTimeout)
+	defer cancel()
+	snap, err := sessionDoc(uuid).Get(ctx)
+	if err != nil {
+		http.Error(w, "Unknown session", http.StatusNotFound)
+		return
+	}
+	var state sessionState
+	if err := snap.DataTo(&state); err != nil {
+		log.Printf("Failed to decode session state: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Re-validate credentials against the session's org/sensor on every
+	// PATCH/PUT: the UUID alone (leaked via the Location/Docker-Upload-UUID
+	// headers into logs, proxies, browser history, etc.) must not be usable
+	// as a bearer credential in its own right.
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	apiKey := strings.TrimPrefix(auth, "Bearer ")
+	if !validateAPIKey(r, state.OrgID, apiKey) || !validateSensorID(r, state.OrgID, state.SensorID) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if state.Closed {
+		http.Error(w, "Session already closed", http.StatusGone)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPatch:
+		handleSessionPatch(w, r, uuid, state)
+	case http.MethodPut:
+		handleSessionCommit(w, r, uuid, state)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleSessionPatch(w http.ResponseWriter, r *http.Request, uuid string, state sessionState) {
+	status := "error"
+	defer func() {
+		metrics.UploadRequestsTotal.WithLabelValues(metrics.OrgLabel(state.OrgID), status).Inc()
+	}()
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSessionChunkBytes+1))
+	if err != nil {
+		status = "bad_request"
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxSessionChunkBytes {
+		status = "bad_request"
+		http.Error(w, fmt.Sprintf("Chunk exceeds max size of %d bytes", maxSessionChunkBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	readings, err := decodeBatch(body)
+	if err != nil {
+		status = "bad_request"
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var lineProtocol [][]byte
+	for _, reading := range readings {
+		if reading.SensorID == nil || *reading.SensorID == "" {
+			reading.SensorID = &state.SensorID
+		}
+		point, err := buildPoint(reading, state.OrgID)
+		if err != nil {
+			status = "bad_request"
+			http.Error(w, "Bad Reading: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		bytes, err := point.MarshalBinary(0)
+		if err != nil {
+			http.Error(w, "Failed to process data", http.StatusInternalServerError)
+			return
+		}
+		lineProtocol = append(lineProtocol, bytes)
+	}
+
+	// Rate‐limit per (apiKey, sensorID), reserving one token per reading in
+	// the chunk, same as a batch upload, so a 5 MiB PATCH can't bypass the
+	// limiter a single-reading upload is bound by.
+	if len(lineProtocol) > 0 {
+		apiKey := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ") // validated in UploadSessionItemHandler
+		if ok, err := rateLimiter.Reserve(rateLimiterKey(apiKey, state.SensorID), int64(len(lineProtocol))); err != nil || !ok {
+			status = "rate_limited"
+			metrics.RateLimitedTotal.WithLabelValues(metrics.OrgLabel(state.OrgID)).Inc()
+			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	// Enforce the retention-tier quota incrementally, rather than only at
+	// session close, so a runaway backfill is rejected mid-stream.
+	// Only the new points in this chunk are added on top of orgQuotaCount
+	// here. With the Redis quota counter configured, that count already
+	// includes every prior chunk's incrQuota call; without it, incrQuota is
+	// a no-op and the Influx COUNT includes prior chunks only once the spool
+	// has flushed them.
+	if !validateBatchCountLimit(r, state.OrgID, state.RetentionCode, state.Bucket, len(lineProtocol)) {
+		status = "rejected"
+		http.Error(w, "Quota exceeded", http.StatusPaymentRequired)
+		return
+	}
+
+	if len(lineProtocol) > 0 {
+		payload := joinLineProtocol(lineProtocol)
+		if err := spools[state.Bucket].Append(payload); err != nil {
+			log.Printf("Spool append error: %v", err)
+			http.Error(w, "Failed to process data", http.StatusInternalServerError)
+			return
+		}
+		incrQuota(r, state.OrgID, state.Bucket, int64(len(lineProtocol)))
+		metrics.UploadBytesTotal.WithLabelValues(metrics.OrgLabel(state.OrgID), state.Bucket).Add(float64(len(payload)))
+	}
+
+	state.HashState, err = rollingHash(state.HashState, body)
+	if err != nil {
+		log.Printf("Failed to update rolling hash: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	state.Offset += int64(len(body))
+	state.QuotaConsumed += int64(len(lineProtocol))
+	state.UpdatedAt = time.Now().UTC()
+
+	ctx, cancel := setDeadline(r, firestoreTimeout)
+	defer cancel()
+	if _, err := sessionDoc(uuid).Set(ctx, state); err != nil {
+		log.Printf("Failed to persist session state: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	status = "accepted"
+
+	w.Header().Set("Location", "/v1/upload/session/"+uuid)
+	w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", state.Offset-1))
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte(`{"status":"accepted","offset":` + strconv.FormatInt(state.Offset, 10) + `}`))
+}
+
+func handleSessionCommit(w http.ResponseWriter, r *http.Request, uuid string, state sessionState) {
+	status := "error"
+	defer func() {
+		metrics.UploadRequestsTotal.WithLabelValues(metrics.OrgLabel(state.OrgID), status).Inc()
+	}()
+
+	// A final chunk may optionally be carried on the commit PUT, same as a PATCH.
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSessionChunkBytes+1))
+	if err != nil {
+		status = "bad_request"
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxSessionChunkBytes {
+		status = "bad_request"
+		http.Error(w, fmt.Sprintf("Chunk exceeds max size of %d bytes", maxSessionChunkBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if len(body) > 0 {
+		readings, err := decodeBatch(body)
+		if err != nil {
+			status = "bad_request"
+			http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var lineProtocol [][]byte
+		for _, reading := range readings {
+			if reading.SensorID == nil || *reading.SensorID == "" {
+				reading.SensorID = &state.SensorID
+			}
+			point, err := buildPoint(reading, state.OrgID)
+			if err != nil {
+				status = "bad_request"
+				http.Error(w, "Bad Reading: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			bytes, err := point.MarshalBinary(0)
+			if err != nil {
+				http.Error(w, "Failed to process data", http.StatusInternalServerError)
+				return
+			}
+			lineProtocol = append(lineProtocol, bytes)
+		}
+
+		if len(lineProtocol) > 0 {
+			apiKey := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ") // validated in UploadSessionItemHandler
+			if ok, err := rateLimiter.Reserve(rateLimiterKey(apiKey, state.SensorID), int64(len(lineProtocol))); err != nil || !ok {
+				status = "rate_limited"
+				metrics.RateLimitedTotal.WithLabelValues(metrics.OrgLabel(state.OrgID)).Inc()
+				http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+
+			if !validateBatchCountLimit(r, state.OrgID, state.RetentionCode, state.Bucket, len(lineProtocol)) {
+				status = "rejected"
+				http.Error(w, "Quota exceeded", http.StatusPaymentRequired)
+				return
+			}
+
+			payload := joinLineProtocol(lineProtocol)
+			if err := spools[state.Bucket].Append(payload); err != nil {
+				log.Printf("Spool append error: %v", err)
+				http.Error(w, "Failed to process data", http.StatusInternalServerError)
+				return
+			}
+			incrQuota(r, state.OrgID, state.Bucket, int64(len(lineProtocol)))
+			metrics.UploadBytesTotal.WithLabelValues(metrics.OrgLabel(state.OrgID), state.Bucket).Add(float64(len(payload)))
+			state.QuotaConsumed += int64(len(lineProtocol))
+		}
+
+		state.HashState, err = rollingHash(state.HashState, body)
+		if err != nil {
+			log.Printf("Failed to update rolling hash: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		state.Offset += int64(len(body))
+	}
+
+	digestParam := r.URL.Query().Get("digest")
+	if digestParam != "" {
+		wantDigest := strings.TrimPrefix(digestParam, "sha256:")
+		gotDigest, err := digestFromHashState(state.HashState)
+		if err != nil {
+			log.Printf("Failed to finalize digest: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if !strings.EqualFold(wantDigest, gotDigest) {
+			status = "bad_request"
+			http.Error(w, "Digest mismatch", http.StatusBadRequest)
+			return
+		}
+	}
+
+	state.Closed = true
+	state.UpdatedAt = time.Now().UTC()
+
+	ctx, cancel := setDeadline(r, firestoreTimeout)
+	defer cancel()
+	if _, err := sessionDoc(uuid).Set(ctx, state); err != nil {
+		log.Printf("Failed to close session: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	status = "committed"
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(`{"status":"committed","offset":` + strconv.FormatInt(state.Offset, 10) + `}`))
+}
+
+func sessionDoc(uuid string) *firestore.DocumentRef {
+	return firestoreClient.Collection(sessionCollection).Doc(uuid)
+}
+
+func newSessionUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // variant 10
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// rollingHash unmarshals a sha256 hasher's saved state, writes chunk, and
+// returns the re-marshaled state, so the digest can be verified across
+// independent PATCH calls without holding every byte in memory.
+func rollingHash(state []byte, chunk []byte) ([]byte, error) {
+	h := sha256.New()
+	if len(state) > 0 {
+		if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+			return nil, err
+		}
+	}
+	h.Write(chunk)
+	return h.(encoding.BinaryMarshaler).MarshalBinary()
+}
+
+func digestFromHashState(state []byte) (string, error) {
+	h := sha256.New()
+	if len(state) > 0 {
+		if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
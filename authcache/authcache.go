@@ -0,0 +1,67 @@
+// Package authcache memoizes Firestore API-key and sensor-id lookups so the
+// upload hot path isn't dominated by a Firestore round trip on every point.
+// Positive results are cached longer than negative ones, and both get a
+// small jitter on their expiry so many keys created at the same instant
+// don't all expire together and stampede Firestore.
+package authcache
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// PositiveTTL is how long a confirmed-valid key or sensor is cached.
+	PositiveTTL = 5 * time.Minute
+	// NegativeTTL is how long a confirmed-invalid key or sensor is cached.
+	NegativeTTL = 30 * time.Second
+
+	jitterFraction = 0.1
+)
+
+type entry struct {
+	valid    bool
+	expireAt time.Time
+}
+
+// Cache is a TTL cache of boolean lookup results keyed by string.
+type Cache struct {
+	entries sync.Map // string -> entry
+}
+
+// New returns an empty Cache.
+func New() *Cache {
+	return &Cache{}
+}
+
+// Get returns the cached result for key and whether it was found and still fresh.
+func (c *Cache) Get(key string) (valid bool, found bool) {
+	v, ok := c.entries.Load(key)
+	if !ok {
+		return false, false
+	}
+	e := v.(entry)
+	if time.Now().After(e.expireAt) {
+		c.entries.Delete(key)
+		return false, false
+	}
+	return e.valid, true
+}
+
+// Set memoizes valid for key, using PositiveTTL or NegativeTTL depending on
+// the result, each with a small jitter applied.
+func (c *Cache) Set(key string, valid bool) {
+	ttl := NegativeTTL
+	if valid {
+		ttl = PositiveTTL
+	}
+	ttl += time.Duration(rand.Float64() * jitterFraction * float64(ttl))
+	c.entries.Store(key, entry{valid: valid, expireAt: time.Now().Add(ttl)})
+}
+
+// Invalidate evicts key, e.g. when a dashboard revokes an API key or
+// deletes a sensor.
+func (c *Cache) Invalidate(key string) {
+	c.entries.Delete(key)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"influxdb_go_client/api"
+	"influxdb_go_client/metrics"
 	"log"
 	"net/http"
 
@@ -27,8 +28,15 @@ func main() {
 		w.Write([]byte(`{"status":"healthy"}`))
 	})
 
-	// Register the upload endpoint
+	// Register the upload endpoints
 	http.HandleFunc("/v1/upload", api.Handler)
+	http.HandleFunc("/v1/upload/batch", api.BatchUploadHandler)
+	http.HandleFunc("/v1/admin/invalidate", api.InvalidateHandler)
+	http.HandleFunc("/v1/upload/session", api.UploadSessionHandler)
+	http.HandleFunc("/v1/upload/session/", api.UploadSessionItemHandler)
+
+	// Prometheus metrics for the ingest pipeline
+	http.HandleFunc("/metrics", metrics.Handler)
 
 	log.Println("📡 DeviceData API listening on :8080")
 	log.Println("✅ /v1/upload endpoint is now available for local development")
@@ -0,0 +1,24 @@
+package metrics
+
+import (
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var handler = promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+
+// Handler serves /metrics, guarded by a bearer token from the
+// METRICS_TOKEN environment variable.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	token := os.Getenv("METRICS_TOKEN")
+	auth := r.Header.Get("Authorization")
+	if token == "" || !strings.HasPrefix(auth, "Bearer ") || strings.TrimPrefix(auth, "Bearer ") != token {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	handler.ServeHTTP(w, r)
+}
@@ -0,0 +1,96 @@
+// Package metrics exposes Prometheus metrics for the ingest pipeline:
+// request/byte counters, Influx and Firestore latency histograms, rate
+// limit rejections, and per-bucket quota usage. Labels are declared here
+// so every caller uses the same metric and label names.
+package metrics
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxDistinctOrgs caps the number of distinct "org" label values exported.
+// Beyond this, additional orgs are folded into one of orgOverflowBuckets
+// hashed buckets so a runaway number of tenants can't blow up series
+// cardinality.
+const (
+	maxDistinctOrgs     = 200
+	orgOverflowBuckets  = 16
+	orgOverflowLabelFmt = "overflow-%d"
+)
+
+var (
+	UploadRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "salkaro_upload_requests_total",
+		Help: "Total upload requests, by org and response status.",
+	}, []string{"org", "status"})
+
+	UploadBytesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "salkaro_upload_bytes_total",
+		Help: "Total bytes accepted for upload, by org and retention bucket.",
+	}, []string{"org", "bucket"})
+
+	InfluxWriteSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "salkaro_influx_write_seconds",
+		Help: "Latency of InfluxDB write calls.",
+	})
+
+	FirestoreLookupSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "salkaro_firestore_lookup_seconds",
+		Help: "Latency of Firestore lookups, by operation.",
+	}, []string{"op"})
+
+	RateLimitedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "salkaro_rate_limited_total",
+		Help: "Total requests rejected by the rate limiter, by org.",
+	}, []string{"org"})
+
+	QuotaUsed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "salkaro_quota_used",
+		Help: "Current retention-tier quota usage, by org and bucket.",
+	}, []string{"org", "bucket"})
+)
+
+// Registry is the registry /metrics serves from.
+var Registry = prometheus.NewRegistry()
+
+func init() {
+	Registry.MustRegister(
+		UploadRequestsTotal,
+		UploadBytesTotal,
+		InfluxWriteSeconds,
+		FirestoreLookupSeconds,
+		RateLimitedTotal,
+		QuotaUsed,
+	)
+}
+
+var (
+	seenOrgsMu sync.Mutex
+	seenOrgs   = make(map[string]struct{})
+)
+
+// OrgLabel returns the label value to use for org on a metric, capping
+// cardinality at maxDistinctOrgs: once that many distinct orgs have been
+// seen, every additional org is folded into one of a small number of
+// hashed overflow buckets instead of getting its own series.
+func OrgLabel(org string) string {
+	seenOrgsMu.Lock()
+	defer seenOrgsMu.Unlock()
+
+	if _, ok := seenOrgs[org]; ok {
+		return org
+	}
+	if len(seenOrgs) < maxDistinctOrgs {
+		seenOrgs[org] = struct{}{}
+		return org
+	}
+
+	sum := sha256.Sum256([]byte(org))
+	bucket := binary.BigEndian.Uint32(sum[:4]) % orgOverflowBuckets
+	return fmt.Sprintf(orgOverflowLabelFmt, bucket)
+}
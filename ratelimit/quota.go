@@ -0,0 +1,56 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// reconcileTTL is how long a quota counter is trusted before it's reseeded
+// from the source of truth (an Influx COUNT query) on next use. This turns
+// the expensive COUNT scan into a lazy, infrequent background cost instead
+// of a per-request one.
+const reconcileTTL = 10 * time.Minute
+
+// SeedFunc computes the authoritative current count for a quota key,
+// typically an Influx COUNT(*) query.
+type SeedFunc func(ctx context.Context) (int64, error)
+
+// QuotaCounter tracks retention-tier usage in Redis so the hot path is a
+// single INCR instead of a full-table COUNT scan against InfluxDB.
+type QuotaCounter struct {
+	client *redis.Client
+}
+
+// NewQuotaCounter returns a QuotaCounter backed by client.
+func NewQuotaCounter(client *redis.Client) *QuotaCounter {
+	return &QuotaCounter{client: client}
+}
+
+// Incr records n newly-written points against key and returns the updated
+// total.
+func (q *QuotaCounter) Incr(ctx context.Context, key string, n int64) (int64, error) {
+	return q.client.IncrBy(ctx, key, n).Result()
+}
+
+// Get returns the current count for key, seeding it from seed if it's
+// missing or has gone stale past reconcileTTL.
+func (q *QuotaCounter) Get(ctx context.Context, key string, seed SeedFunc) (int64, error) {
+	count, err := q.client.Get(ctx, key).Int64()
+	if err == nil {
+		return count, nil
+	}
+	if err != redis.Nil {
+		return 0, err
+	}
+
+	actual, err := seed(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if err := q.client.Set(ctx, key, actual, reconcileTTL).Err(); err != nil {
+		return 0, err
+	}
+	return actual, nil
+}
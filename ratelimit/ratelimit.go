@@ -0,0 +1,60 @@
+// Package ratelimit provides per-key rate limiting with two interchangeable
+// backends: an in-process token bucket (fine for a single instance) and a
+// Redis-backed one that shares state across every serverless instance.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Limiter decides whether a caller identified by key may proceed.
+type Limiter interface {
+	// Allow reports whether a single event for key is allowed right now.
+	Allow(key string) bool
+	// Reserve reports whether n events for key are allowed right now,
+	// consuming them if so.
+	Reserve(key string, n int64) (bool, error)
+}
+
+// InProcess is a per-instance token bucket limiter, keyed by string. It's
+// the same behavior the API used before the Redis-backed limiter existed,
+// and remains the default when REDIS_ADDR isn't configured.
+type InProcess struct {
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+	rps      float64
+	burst    int
+}
+
+// NewInProcess returns an InProcess limiter allowing rps events per second
+// per key, with the given burst size.
+func NewInProcess(rps float64, burst int) *InProcess {
+	return &InProcess{
+		limiters: make(map[string]*rate.Limiter),
+		rps:      rps,
+		burst:    burst,
+	}
+}
+
+func (p *InProcess) limiterFor(key string) *rate.Limiter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	limiter, exists := p.limiters[key]
+	if !exists {
+		limiter = rate.NewLimiter(rate.Limit(p.rps), p.burst)
+		p.limiters[key] = limiter
+	}
+	return limiter
+}
+
+func (p *InProcess) Allow(key string) bool {
+	return p.limiterFor(key).Allow()
+}
+
+func (p *InProcess) Reserve(key string, n int64) (bool, error) {
+	return p.limiterFor(key).AllowN(time.Now(), int(n)), nil
+}
@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// windowedCounterScript implements a fixed-window counter with a single
+// INCRBY + conditional EXPIRE, so each check is one round trip regardless of
+// how many serverless instances are sharing the key.
+const windowedCounterScript = `
+local current = redis.call("INCRBY", KEYS[1], ARGV[1])
+if tonumber(current) == tonumber(ARGV[1]) then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+if tonumber(current) > tonumber(ARGV[3]) then
+	redis.call("DECRBY", KEYS[1], ARGV[1])
+	return 0
+end
+return 1
+`
+
+// Redis is a distributed Limiter backed by a fixed-window counter in Redis,
+// shared across every instance of the API. A transient Redis error (timeout,
+// dropped connection) falls back to an in-process limiter with the same
+// limit rather than rejecting every caller outright: a single instance
+// under-enforcing for the duration of an outage is preferable to a hard
+// 429 storm across the whole fleet.
+type Redis struct {
+	client   *redis.Client
+	limit    int64
+	window   time.Duration
+	script   *redis.Script
+	fallback Limiter
+}
+
+// NewRedis returns a Redis-backed Limiter allowing up to limit events per
+// key within each window.
+func NewRedis(client *redis.Client, limit int64, window time.Duration) *Redis {
+	return &Redis{
+		client:   client,
+		limit:    limit,
+		window:   window,
+		script:   redis.NewScript(windowedCounterScript),
+		fallback: NewInProcess(float64(limit)/window.Seconds(), int(limit)),
+	}
+}
+
+func (r *Redis) Allow(key string) bool {
+	ok, err := r.Reserve(key, 1)
+	return err == nil && ok
+}
+
+func (r *Redis) Reserve(key string, n int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	res, err := r.script.Run(ctx, r.client, []string{key}, n, r.window.Milliseconds(), r.limit).Int()
+	if err != nil {
+		log.Printf("ratelimit: redis unavailable, falling back to in-process limiter: %v", err)
+		return r.fallback.Reserve(key, n)
+	}
+	return res == 1, nil
+}
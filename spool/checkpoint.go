@@ -0,0 +1,52 @@
+package spool
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const checkpointFile = "checkpoint"
+
+// checkpoint tracks the segment index and byte offset up to which every
+// record has been successfully written to InfluxDB. It is only advanced
+// after a write acknowledgment, so a crash mid-flush simply replays the
+// same records again on restart.
+type checkpoint struct {
+	path         string
+	segmentIndex int
+	byteOffset   int64
+}
+
+func loadCheckpoint(dir string) (*checkpoint, error) {
+	path := filepath.Join(dir, checkpointFile)
+	cp := &checkpoint{path: path}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cp, nil
+		}
+		return nil, err
+	}
+
+	_, err = fmt.Sscanf(string(data), "%d %d", &cp.segmentIndex, &cp.byteOffset)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt spool checkpoint: %w", err)
+	}
+	return cp, nil
+}
+
+func (c *checkpoint) save(segmentIndex int, byteOffset int64) error {
+	tmp := c.path + ".tmp"
+	content := fmt.Sprintf("%d %d", segmentIndex, byteOffset)
+	if err := os.WriteFile(tmp, []byte(content), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return err
+	}
+	c.segmentIndex = segmentIndex
+	c.byteOffset = byteOffset
+	return nil
+}
@@ -0,0 +1,231 @@
+// Package spool implements a durable write-ahead log for InfluxDB writes.
+//
+// Every accepted reading is appended to an on-disk segment and fsynced
+// before the HTTP handler responds, so the response no longer depends on
+// InfluxDB being reachable. A background goroutine drains unflushed
+// segments into InfluxDB, batching records and backing off exponentially
+// on failure, and replays anything left over from a previous crash on
+// startup. This mirrors the ingester-WAL pattern used by time-series
+// systems like Cortex/Loki.
+package spool
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// WriteFunc flushes a batch of raw line-protocol records to InfluxDB.
+type WriteFunc func(ctx context.Context, records [][]byte) error
+
+const (
+	flushInterval  = 2 * time.Second
+	flushBatchSize = 500
+	minBackoff     = 500 * time.Millisecond
+	maxBackoff     = 30 * time.Second
+)
+
+// Spool is a durable, replayable queue of records awaiting an InfluxDB write.
+type Spool struct {
+	dir     string
+	writeFn WriteFunc
+
+	mu      sync.Mutex
+	active  *segment
+	nextIdx int
+
+	checkpoint *checkpoint
+
+	notify chan struct{}
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// Open creates (if necessary) the spool directory, loads the flush
+// checkpoint, opens a fresh active segment after any left from a previous
+// run, and returns a Spool ready to accept writes. It does not replay
+// anything itself: call Start to begin the background writer, which
+// replays unflushed segments.
+func Open(dir string, writeFn WriteFunc) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	cp, err := loadCheckpoint(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	nextIdx := 0
+	if len(indexes) > 0 {
+		nextIdx = indexes[len(indexes)-1] + 1
+	}
+
+	active, err := openSegment(dir, nextIdx)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Spool{
+		dir:        dir,
+		writeFn:    writeFn,
+		active:     active,
+		nextIdx:    nextIdx + 1,
+		checkpoint: cp,
+		notify:     make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	return s, nil
+}
+
+// Append durably persists a record, rotating to a new segment if the active
+// one has grown past maxSegmentBytes. It returns once the record has been
+// fsynced to disk; the InfluxDB write happens asynchronously.
+func (s *Spool) Append(record []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.active.append(record); err != nil {
+		return err
+	}
+
+	if s.active.size >= maxSegmentBytes {
+		if err := s.active.close(); err != nil {
+			return err
+		}
+		next, err := openSegment(s.dir, s.nextIdx)
+		if err != nil {
+			return err
+		}
+		s.nextIdx++
+		s.active = next
+	}
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Start launches the background goroutine that replays unflushed segments
+// and then continuously batches new records into InfluxDB.
+func (s *Spool) Start(ctx context.Context) {
+	s.wg.Add(1)
+	go s.run(ctx)
+}
+
+// Close stops the background writer and waits for it to exit.
+func (s *Spool) Close() error {
+	close(s.done)
+	s.wg.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active.close()
+}
+
+func (s *Spool) run(ctx context.Context) {
+	defer s.wg.Done()
+
+	backoff := minBackoff
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.drain(ctx); err != nil {
+			log.Printf("spool: flush failed, backing off %s: %v", backoff, err)
+			select {
+			case <-time.After(backoff):
+			case <-s.done:
+				return
+			case <-ctx.Done():
+				return
+			}
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+		backoff = minBackoff
+
+		select {
+		case <-s.notify:
+		case <-ticker.C:
+		case <-s.done:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// drain replays every record after the checkpoint, in flushBatchSize
+// chunks, advancing the checkpoint only after a successful Influx write.
+func (s *Spool) drain(ctx context.Context) error {
+	for {
+		indexes, err := listSegments(s.dir)
+		if err != nil {
+			return err
+		}
+
+		advanced := false
+		for _, idx := range indexes {
+			if idx < s.checkpoint.segmentIndex {
+				continue
+			}
+			offset := int64(0)
+			if idx == s.checkpoint.segmentIndex {
+				offset = s.checkpoint.byteOffset
+			}
+
+			path := segmentPath(s.dir, idx)
+			records, newOffset, err := readRecords(path, offset)
+			if err != nil {
+				return err
+			}
+			if len(records) == 0 {
+				continue
+			}
+
+			for start := 0; start < len(records); start += flushBatchSize {
+				end := start + flushBatchSize
+				if end > len(records) {
+					end = len(records)
+				}
+				batch := records[start:end]
+
+				writeCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+				err := s.writeFn(writeCtx, batch)
+				cancel()
+				if err != nil {
+					return err
+				}
+			}
+
+			if err := s.checkpoint.save(idx, newOffset); err != nil {
+				return err
+			}
+			advanced = true
+
+			// Sealed (non-active) segments fully flushed can be removed.
+			s.mu.Lock()
+			isActive := s.active.index == idx
+			s.mu.Unlock()
+			if !isActive {
+				os.Remove(path)
+			}
+		}
+
+		if !advanced {
+			return nil
+		}
+	}
+}